@@ -0,0 +1,148 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	cniv1 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// defaultCNICacheDir is where CNICache stores the result of each CNI ADD
+// invocation, keyed by container ID, so controllers don't have to re-derive
+// the gateway/routes/IPs the CNI plugin already worked out.
+const defaultCNICacheDir = "/var/lib/kube-router/cni-cache"
+
+// CNICache persists the cniv1.Result of a CNI ADD to disk, one JSON file per
+// container ID, so it can be looked up again after the process that ran the
+// CNI plugin has exited.
+type CNICache struct {
+	dir string
+}
+
+// NewCNICache returns a CNICache rooted at dir. An empty dir uses
+// defaultCNICacheDir.
+func NewCNICache(dir string) *CNICache {
+	if dir == "" {
+		dir = defaultCNICacheDir
+	}
+	return &CNICache{dir: dir}
+}
+
+// defaultCNICache is the cache package-level helpers operate on.
+var defaultCNICache = NewCNICache(defaultCNICacheDir)
+
+// Store persists result under containerID, creating the cache directory if
+// it does not already exist.
+func (c *CNICache) Store(containerID string, result *cniv1.Result) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create CNI cache dir %s: %v", c.dir, err)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CNI result for %s: %v", containerID, err)
+	}
+
+	if err = ioutil.WriteFile(c.path(containerID), data, 0644); err != nil {
+		return fmt.Errorf("failed to write CNI result cache for %s: %v", containerID, err)
+	}
+
+	return nil
+}
+
+// Load returns the cniv1.Result previously stored for containerID.
+func (c *CNICache) Load(containerID string) (*cniv1.Result, error) {
+	data, err := ioutil.ReadFile(c.path(containerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CNI result cache for %s: %v", containerID, err)
+	}
+
+	result := &cniv1.Result{}
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("failed to parse CNI result cache for %s: %v", containerID, err)
+	}
+
+	return result, nil
+}
+
+// Evict removes the cached result for containerID, if any. It is a no-op
+// when nothing was ever cached for that container, since that is the
+// common case for a pod that never reached a successful CNI ADD.
+func (c *CNICache) Evict(containerID string) error {
+	if err := os.Remove(c.path(containerID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to evict CNI result cache for %s: %v", containerID, err)
+	}
+	return nil
+}
+
+func (c *CNICache) path(containerID string) string {
+	return filepath.Join(c.dir, containerID+".json")
+}
+
+// StoreCNIResult caches result under containerID in the default CNI cache
+// directory. Call this right after a successful CNI ADD.
+func StoreCNIResult(containerID string, result *cniv1.Result) error {
+	return defaultCNICache.Store(containerID, result)
+}
+
+// LoadCNIResult returns the cached cniv1.Result for containerID, for
+// controllers (network-policy, proxy, routing) that need the pod's gateway,
+// routes or IPs without re-invoking or re-parsing CNI config themselves.
+func LoadCNIResult(containerID string) (*cniv1.Result, error) {
+	return defaultCNICache.Load(containerID)
+}
+
+// EvictCNIResult removes the cached result for containerID. Call this on
+// pod delete so the cache does not grow without bound.
+func EvictCNIResult(containerID string) error {
+	return defaultCNICache.Evict(containerID)
+}
+
+// GatewaysFromCNIResult returns the first gateway configured per address
+// family in result.IPs, IPv4 first then IPv6, instead of assuming the
+// gateway is always the ".1" address of the pod CIDR.
+func GatewaysFromCNIResult(result *cniv1.Result) []net.IP {
+	var v4Gateway, v6Gateway net.IP
+	for _, ipConfig := range result.IPs {
+		if ipConfig == nil || ipConfig.Gateway == nil {
+			continue
+		}
+		if ipConfig.Gateway.To4() != nil {
+			if v4Gateway == nil {
+				v4Gateway = ipConfig.Gateway
+			}
+		} else if v6Gateway == nil {
+			v6Gateway = ipConfig.Gateway
+		}
+	}
+
+	var gateways []net.IP
+	if v4Gateway != nil {
+		gateways = append(gateways, v4Gateway)
+	}
+	if v6Gateway != nil {
+		gateways = append(gateways, v6Gateway)
+	}
+	return gateways
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	cniv1 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+func recordedHostLocalResult(t *testing.T, resultJSON string) *cniv1.Result {
+	t.Helper()
+	result := &cniv1.Result{}
+	if err := json.Unmarshal([]byte(resultJSON), result); err != nil {
+		t.Fatalf("failed to parse recorded CNI result fixture: %v", err)
+	}
+	return result
+}
+
+func Test_CNICache_StoreLoadEvict(t *testing.T) {
+	testcases := []struct {
+		name         string
+		resultJSON   string
+		wantGateways []net.IP
+	}{
+		{
+			"v4 result",
+			`{"cniVersion":"1.0.0","ips":[{"address":"172.17.0.5/24","gateway":"172.17.0.1"}]}`,
+			[]net.IP{net.ParseIP("172.17.0.1")},
+		},
+		{
+			"v6 result",
+			`{"cniVersion":"1.0.0","ips":[{"address":"fd00::5/64","gateway":"fd00::1"}]}`,
+			[]net.IP{net.ParseIP("fd00::1")},
+		},
+		{
+			"dual-stack result",
+			`{"cniVersion":"1.0.0","ips":[{"address":"172.17.0.5/24","gateway":"172.17.0.1"},{"address":"fd00::5/64","gateway":"fd00::1"}]}`,
+			[]net.IP{net.ParseIP("172.17.0.1"), net.ParseIP("fd00::1")},
+		},
+	}
+
+	cacheDir, err := ioutil.TempDir("", "kube-router-cni-cache")
+	if err != nil {
+		t.Fatalf("failed to create temp cache dir: %v", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	cache := NewCNICache(cacheDir)
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			containerID := "container-test"
+			result := recordedHostLocalResult(t, testcase.resultJSON)
+
+			if err := cache.Store(containerID, result); err != nil {
+				t.Fatalf("unexpected error storing CNI result: %v", err)
+			}
+
+			loaded, err := cache.Load(containerID)
+			if err != nil {
+				t.Fatalf("unexpected error loading CNI result: %v", err)
+			}
+
+			gateways := GatewaysFromCNIResult(loaded)
+			if !reflect.DeepEqual(gateways, testcase.wantGateways) {
+				t.Logf("actual gateways: %v", gateways)
+				t.Logf("expected gateways: %v", testcase.wantGateways)
+				t.Error("did not get expected gateways from cached CNI result")
+			}
+
+			if err := cache.Evict(containerID); err != nil {
+				t.Fatalf("unexpected error evicting CNI result: %v", err)
+			}
+
+			if _, err := cache.Load(containerID); err == nil {
+				t.Error("expected loading an evicted CNI result to fail")
+			}
+
+			// Evicting an already-evicted (or never-cached) container ID
+			// must not be treated as an error, since pod delete events can
+			// race or arrive more than once.
+			if err := cache.Evict(containerID); err != nil {
+				t.Errorf("unexpected error re-evicting an absent CNI result: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// supportedCNIVersions are the CNI spec versions kube-router knows how to
+// read and write. The empty string is included because a number of
+// real-world host-local .conf files in the wild omit cniVersion entirely.
+var supportedCNIVersions = map[string]bool{
+	"":      true,
+	"0.3.0": true,
+	"0.3.1": true,
+	"0.4.0": true,
+	"1.0.0": true,
+}
+
+// CNIConfig is a typed, version-aware model of a CNI network configuration
+// file. It understands both the single-plugin ".conf" layout, where the
+// plugin's own fields (bridge, ipam, type, ...) sit alongside cniVersion and
+// name at the top level, and the multi-plugin ".conflist" layout, where
+// those plugins live in a "plugins" array. Fields this package does not
+// recognize are preserved in Extra and re-emitted on Save so unrelated
+// CNI config content is never silently dropped.
+type CNIConfig struct {
+	CNIVersion string
+	Name       string
+
+	// Plugin holds the single plugin of a ".conf" file. It is mutually
+	// exclusive with Plugins.
+	Plugin *CNIPlugin
+
+	// Plugins holds the chained plugin list of a ".conflist" file. It is
+	// mutually exclusive with Plugin.
+	Plugins []*CNIPlugin
+
+	Extra map[string]json.RawMessage
+}
+
+// CNIPlugin is a single entry of a CNI conflist's "plugins" array, or the
+// implicit plugin of a flat ".conf" file.
+type CNIPlugin struct {
+	Type string
+	IPAM *IPAMConfig
+
+	Extra map[string]json.RawMessage
+}
+
+// IPAMConfig is the "ipam" block of a CNIPlugin. Subnet is used by the
+// single-family host-local form; Ranges is used by the multi-family form
+// that host-local adopted for dual-stack.
+type IPAMConfig struct {
+	Type   string
+	Subnet string
+	Ranges [][]HostLocalRange
+
+	Extra map[string]json.RawMessage
+}
+
+// HostLocalRange is one entry of an IPAMConfig's "ranges" list.
+type HostLocalRange struct {
+	Subnet string
+
+	Extra map[string]json.RawMessage
+}
+
+// LoadCNIConfig reads and parses the CNI config file at path.
+func LoadCNIConfig(path string) (*CNIConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CNI conf file: %v", err)
+	}
+
+	cniConfig := &CNIConfig{}
+	if err = json.Unmarshal(data, cniConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse json from CNI conf file: %v", err)
+	}
+
+	if err = validateCNIVersion(cniConfig.CNIVersion); err != nil {
+		return nil, fmt.Errorf("CNI conf file %s: %v", path, err)
+	}
+
+	return cniConfig, nil
+}
+
+// Save marshals the config back to JSON and writes it to path.
+func (c *CNIConfig) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CNI config due to: %v", err)
+	}
+
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write CNI config file due to: %v", err)
+	}
+
+	return nil
+}
+
+// UpgradeCNIVersion rewrites the CNIVersion field to version. It is the
+// caller's responsibility to only request an upgrade, never a downgrade,
+// since kube-router does not know how to strip fields a newer spec version
+// introduced.
+func (c *CNIConfig) UpgradeCNIVersion(version string) error {
+	if err := validateCNIVersion(version); err != nil {
+		return err
+	}
+	c.CNIVersion = version
+	return nil
+}
+
+func validateCNIVersion(version string) error {
+	if !supportedCNIVersions[version] {
+		return fmt.Errorf("unsupported CNI version %q", version)
+	}
+	return nil
+}
+
+// ipamConfig returns the IPAM block belonging to this config's plugin (.conf
+// form) or, for a conflist, the bridge plugin's, regardless of where in the
+// chain it sits. If the bridge plugin has no ipam block yet, one is added
+// to it.
+func (c *CNIConfig) ipamConfig() (*IPAMConfig, error) {
+	if c.Plugin != nil {
+		if c.Plugin.IPAM == nil {
+			c.Plugin.IPAM = &IPAMConfig{}
+		}
+		return c.Plugin.IPAM, nil
+	}
+
+	bridgePlugin := findBridgePlugin(c.Plugins)
+	if bridgePlugin == nil {
+		return nil, fmt.Errorf("could not find the bridge/host-local plugin in CNI conf file")
+	}
+
+	if bridgePlugin.IPAM == nil {
+		bridgePlugin.IPAM = &IPAMConfig{}
+	}
+	return bridgePlugin.IPAM, nil
+}
+
+// findBridgePlugin returns the bridge/host-local plugin out of a conflist's
+// chained plugins, identifying it by type rather than by position or by
+// which plugin happens to already carry an ipam block: neither a plugin's
+// array index nor the presence of an ipam block on some other plugin says
+// anything about which plugin actually owns pod CIDR allocation.
+func findBridgePlugin(plugins []*CNIPlugin) *CNIPlugin {
+	for _, plugin := range plugins {
+		if plugin.Type == "bridge" {
+			return plugin
+		}
+	}
+
+	for _, plugin := range plugins {
+		if plugin.IPAM != nil && plugin.IPAM.Type == "host-local" {
+			return plugin
+		}
+	}
+
+	return nil
+}
+
+// Subnets returns the pod CIDR subnets configured on this IPAM block,
+// whether it was written in the single-family "subnet" form or the
+// multi-family "ranges" form: the "subnet" field first, then the subnet of
+// the first range in each range set. Further ranges within a range set are
+// not returned.
+func (i *IPAMConfig) Subnets() []string {
+	var subnets []string
+	if i.Subnet != "" {
+		subnets = append(subnets, i.Subnet)
+	}
+	for _, rangeSet := range i.Ranges {
+		if len(rangeSet) > 0 && rangeSet[0].Subnet != "" {
+			subnets = append(subnets, rangeSet[0].Subnet)
+		}
+	}
+	return subnets
+}
+
+// SetSubnets writes subnets into this IPAM block, using the plain "subnet"
+// field for a single CIDR and upgrading to the "ranges" form, one range set
+// per subnet in the given order, when there is more than one. Subnets are
+// not grouped by address family.
+func (i *IPAMConfig) SetSubnets(subnets []string) {
+	switch len(subnets) {
+	case 0:
+		return
+	case 1:
+		i.Subnet = subnets[0]
+		i.Ranges = nil
+	default:
+		i.Subnet = ""
+		i.Ranges = make([][]HostLocalRange, 0, len(subnets))
+		for _, subnet := range subnets {
+			i.Ranges = append(i.Ranges, []HostLocalRange{{Subnet: subnet}})
+		}
+	}
+}
+
+// UnmarshalJSON decodes a CNI config, splitting out the cniVersion/name/
+// plugins fields it understands and keeping everything else in Extra.
+func (c *CNIConfig) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if v, ok := raw["cniVersion"]; ok {
+		if err := json.Unmarshal(v, &c.CNIVersion); err != nil {
+			return err
+		}
+		delete(raw, "cniVersion")
+	}
+
+	if v, ok := raw["name"]; ok {
+		if err := json.Unmarshal(v, &c.Name); err != nil {
+			return err
+		}
+		delete(raw, "name")
+	}
+
+	if v, ok := raw["plugins"]; ok {
+		if err := json.Unmarshal(v, &c.Plugins); err != nil {
+			return err
+		}
+		delete(raw, "plugins")
+		c.Extra = raw
+		return nil
+	}
+
+	// Flat ".conf" layout: whatever is left describes the single plugin.
+	pluginData, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	plugin := &CNIPlugin{}
+	if err := json.Unmarshal(pluginData, plugin); err != nil {
+		return err
+	}
+	c.Plugin = plugin
+
+	return nil
+}
+
+// MarshalJSON re-assembles the config, merging Extra back in so unknown
+// fields round-trip unchanged.
+func (c *CNIConfig) MarshalJSON() ([]byte, error) {
+	out := map[string]json.RawMessage{}
+	for k, v := range c.Extra {
+		out[k] = v
+	}
+
+	if c.CNIVersion != "" {
+		b, err := json.Marshal(c.CNIVersion)
+		if err != nil {
+			return nil, err
+		}
+		out["cniVersion"] = b
+	}
+
+	if c.Name != "" {
+		b, err := json.Marshal(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		out["name"] = b
+	}
+
+	if c.Plugins != nil {
+		b, err := json.Marshal(c.Plugins)
+		if err != nil {
+			return nil, err
+		}
+		out["plugins"] = b
+		return json.Marshal(out)
+	}
+
+	if c.Plugin != nil {
+		pluginJSON, err := json.Marshal(c.Plugin)
+		if err != nil {
+			return nil, err
+		}
+		var pluginMap map[string]json.RawMessage
+		if err := json.Unmarshal(pluginJSON, &pluginMap); err != nil {
+			return nil, err
+		}
+		for k, v := range pluginMap {
+			out[k] = v
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a single plugin entry, splitting out the type/ipam
+// fields it understands and keeping everything else in Extra.
+func (p *CNIPlugin) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if v, ok := raw["type"]; ok {
+		if err := json.Unmarshal(v, &p.Type); err != nil {
+			return err
+		}
+		delete(raw, "type")
+	}
+
+	if v, ok := raw["ipam"]; ok {
+		ipam := &IPAMConfig{}
+		if err := json.Unmarshal(v, ipam); err != nil {
+			return err
+		}
+		p.IPAM = ipam
+		delete(raw, "ipam")
+	}
+
+	p.Extra = raw
+	return nil
+}
+
+// MarshalJSON re-assembles the plugin entry, merging Extra back in so
+// unknown fields (bridge, isDefaultGateway, mtu, ...) round-trip unchanged.
+func (p *CNIPlugin) MarshalJSON() ([]byte, error) {
+	out := map[string]json.RawMessage{}
+	for k, v := range p.Extra {
+		out[k] = v
+	}
+
+	if p.Type != "" {
+		b, err := json.Marshal(p.Type)
+		if err != nil {
+			return nil, err
+		}
+		out["type"] = b
+	}
+
+	if p.IPAM != nil {
+		b, err := json.Marshal(p.IPAM)
+		if err != nil {
+			return nil, err
+		}
+		out["ipam"] = b
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes an ipam block, splitting out the type/subnet/ranges
+// fields it understands and keeping everything else (gateway, rangeStart,
+// routes, ...) in Extra.
+func (i *IPAMConfig) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if v, ok := raw["type"]; ok {
+		if err := json.Unmarshal(v, &i.Type); err != nil {
+			return err
+		}
+		delete(raw, "type")
+	}
+
+	if v, ok := raw["subnet"]; ok {
+		if err := json.Unmarshal(v, &i.Subnet); err != nil {
+			return err
+		}
+		delete(raw, "subnet")
+	}
+
+	if v, ok := raw["ranges"]; ok {
+		if err := json.Unmarshal(v, &i.Ranges); err != nil {
+			return err
+		}
+		delete(raw, "ranges")
+	}
+
+	i.Extra = raw
+	return nil
+}
+
+// MarshalJSON re-assembles the ipam block, merging Extra back in.
+func (i *IPAMConfig) MarshalJSON() ([]byte, error) {
+	out := map[string]json.RawMessage{}
+	for k, v := range i.Extra {
+		out[k] = v
+	}
+
+	if i.Type != "" {
+		b, err := json.Marshal(i.Type)
+		if err != nil {
+			return nil, err
+		}
+		out["type"] = b
+	}
+
+	if i.Subnet != "" {
+		b, err := json.Marshal(i.Subnet)
+		if err != nil {
+			return nil, err
+		}
+		out["subnet"] = b
+	}
+
+	if i.Ranges != nil {
+		b, err := json.Marshal(i.Ranges)
+		if err != nil {
+			return nil, err
+		}
+		out["ranges"] = b
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a host-local range entry, splitting out the subnet
+// field and keeping everything else (gateway, rangeStart, rangeEnd, ...) in
+// Extra.
+func (r *HostLocalRange) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if v, ok := raw["subnet"]; ok {
+		if err := json.Unmarshal(v, &r.Subnet); err != nil {
+			return err
+		}
+		delete(raw, "subnet")
+	}
+
+	r.Extra = raw
+	return nil
+}
+
+// MarshalJSON re-assembles the host-local range entry, merging Extra back
+// in.
+func (r *HostLocalRange) MarshalJSON() ([]byte, error) {
+	out := map[string]json.RawMessage{}
+	for k, v := range r.Extra {
+		out[k] = v
+	}
+
+	if r.Subnet != "" {
+		b, err := json.Marshal(r.Subnet)
+		if err != nil {
+			return nil, err
+		}
+		out["subnet"] = b
+	}
+
+	return json.Marshal(out)
+}
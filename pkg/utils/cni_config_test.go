@@ -0,0 +1,150 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func Test_LoadCNIConfig_ConflistMultipleChainedPlugins(t *testing.T) {
+	content := `{"cniVersion":"0.4.0","name":"mynet","plugins":[` +
+		`{"bridge":"kube-bridge","ipam":{"subnet":"172.17.0.0/24","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"},` +
+		`{"capabilities":{"portMappings":true},"type":"portmap"},` +
+		`{"capabilities":{"bandwidth":true},"type":"bandwidth"}]}`
+
+	file, err := createFile(content, "/tmp/10-kuberouter-chained.conflist")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	cniConfig, err := LoadCNIConfig(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cniConfig.Plugins) != 3 {
+		t.Fatalf("expected 3 chained plugins, got %d", len(cniConfig.Plugins))
+	}
+
+	if cniConfig.Plugins[0].IPAM == nil || cniConfig.Plugins[0].IPAM.Subnet != "172.17.0.0/24" {
+		t.Errorf("expected bridge plugin to carry the ipam subnet, got %+v", cniConfig.Plugins[0].IPAM)
+	}
+
+	if cniConfig.Plugins[1].Type != "portmap" || cniConfig.Plugins[2].Type != "bandwidth" {
+		t.Errorf("expected portmap and bandwidth plugins to survive unmodified, got %+v", cniConfig.Plugins[1:])
+	}
+
+	if err = cniConfig.Save(file.Name()); err != nil {
+		t.Fatalf("unexpected error saving: %v", err)
+	}
+
+	newContent, err := readFile(file.Name())
+	if err != nil {
+		t.Fatalf("failed to read CNI config file: %v", err)
+	}
+
+	if newContent != content {
+		t.Logf("actual CNI config:   %v", newContent)
+		t.Logf("expected CNI config: %v", content)
+		t.Error("round-trip save did not preserve the chained plugins' unknown fields")
+	}
+}
+
+func Test_CNIConfig_UpgradeCNIVersion(t *testing.T) {
+	content := `{"cniVersion":"0.3.0","name":"mynet","plugins":[` +
+		`{"bridge":"kube-bridge","ipam":{"subnet":"172.17.0.0/24","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"},` +
+		`{"type":"portmap"}]}`
+
+	file, err := createFile(content, "/tmp/10-kuberouter-upgrade.conflist")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	cniConfig, err := LoadCNIConfig(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = cniConfig.UpgradeCNIVersion("1.0.0"); err != nil {
+		t.Fatalf("unexpected error upgrading CNI version: %v", err)
+	}
+
+	if err = cniConfig.Save(file.Name()); err != nil {
+		t.Fatalf("unexpected error saving: %v", err)
+	}
+
+	upgraded, err := LoadCNIConfig(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error reloading upgraded config: %v", err)
+	}
+
+	if upgraded.CNIVersion != "1.0.0" {
+		t.Errorf("expected cniVersion 1.0.0 after upgrade, got %q", upgraded.CNIVersion)
+	}
+
+	if len(upgraded.Plugins) != 2 || upgraded.Plugins[1].Type != "portmap" {
+		t.Errorf("expected upgrade to leave the chained plugins intact, got %+v", upgraded.Plugins)
+	}
+}
+
+func Test_InsertPodCidrsInCniSpec_BridgePluginOutOfOrder(t *testing.T) {
+	content := `{"cniVersion":"0.3.0","name":"mynet","plugins":[{"type":"portmap"},{"bridge":"kube-bridge","isDefaultGateway":true,"name":"kubernetes","type":"bridge"}]}`
+
+	file, err := createFile(content, "/tmp/10-kuberouter-bridge-last.conflist")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	cidr := net.IPNet{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)}
+	if err = InsertPodCidrsInCniSpec(file.Name(), []net.IPNet{cidr}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cniConfig, err := LoadCNIConfig(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error reloading config: %v", err)
+	}
+
+	if cniConfig.Plugins[0].IPAM != nil {
+		t.Errorf("expected the portmap plugin to remain without an ipam block, got %+v", cniConfig.Plugins[0].IPAM)
+	}
+
+	bridgePlugin := cniConfig.Plugins[1]
+	if bridgePlugin.Type != "bridge" || bridgePlugin.IPAM == nil || bridgePlugin.IPAM.Subnet != "172.17.0.0/24" {
+		t.Errorf("expected the bridge plugin to receive the subnet even though it is second in the chain, got %+v", bridgePlugin)
+	}
+}
+
+func Test_LoadCNIConfig_UnsupportedVersion(t *testing.T) {
+	content := `{"cniVersion":"9.9.9","name":"mynet","bridge":"kube-bridge","ipam":{"type":"host-local"},"type":"bridge"}`
+
+	file, err := createFile(content, "/tmp/10-kuberouter-unsupported.conf")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = LoadCNIConfig(file.Name()); err == nil {
+		t.Error("expected an error loading a CNI config with an unsupported cniVersion")
+	}
+}
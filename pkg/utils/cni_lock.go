@@ -0,0 +1,138 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultCNILockDir holds the per-CNI-config-path flock files used by
+// WithCNIConfigLock to serialize concurrent mutators.
+const defaultCNILockDir = "/run/kube-router"
+
+// cniLockDir is a var, not a const, purely so tests can point it at a
+// temporary directory instead of requiring /run/kube-router to be
+// writable.
+var cniLockDir = defaultCNILockDir
+
+// WithCNIConfigLock loads the CNI config file at path, holding a per-path
+// flock for the duration, passes it to fn to mutate, and atomically
+// publishes the result: write to a temp file in the same directory, fsync,
+// and os.Rename over path. This keeps a concurrent kubelet/CNI reload from
+// ever observing a truncated or half-written file, and lets future mutators
+// (DNS, MTU, bandwidth plugin, ...) share the same safety without each
+// having to reimplement it.
+func WithCNIConfigLock(path string, fn func(*CNIConfig) error) error {
+	unlock, err := lockCNIConfigPath(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cniConfig, err := LoadCNIConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(cniConfig); err != nil {
+		return err
+	}
+
+	return atomicSaveCNIConfig(cniConfig, path)
+}
+
+// lockCNIConfigPath acquires an exclusive flock on the lock file for path,
+// returning a function that releases it.
+func lockCNIConfigPath(path string) (func(), error) {
+	if err := os.MkdirAll(cniLockDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create CNI lock dir %s: %v", cniLockDir, err)
+	}
+
+	lockPath := filepath.Join(cniLockDir, filepath.Base(path)+".lock")
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CNI lock file %s: %v", lockPath, err)
+	}
+
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to acquire CNI lock %s: %v", lockPath, err)
+	}
+
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+// atomicSaveCNIConfig writes cniConfig to a temp file alongside path,
+// fsyncs it, and renames it over path so readers never observe a partial
+// write.
+func atomicSaveCNIConfig(cniConfig *CNIConfig, path string) error {
+	data, err := json.Marshal(cniConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CNI config due to: %v", err)
+	}
+
+	// ioutil.TempFile always creates with mode 0600; preserve the
+	// existing file's permissions (or fall back to the standard CNI conf
+	// mode for a brand new file) so publishing the temp file over path
+	// doesn't silently tighten it against whatever else reads it, such as
+	// the CNI plugin binary the container runtime invokes.
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(path); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp CNI config file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp CNI config file: %v", err)
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to fsync temp CNI config file: %v", err)
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp CNI config file: %v", err)
+	}
+
+	if err = os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("failed to set permissions on temp CNI config file: %v", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to publish CNI config file: %v", err)
+	}
+
+	return nil
+}
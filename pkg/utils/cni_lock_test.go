@@ -0,0 +1,138 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+)
+
+// withTempCNILockDir points cniLockDir at a fresh temp directory for the
+// duration of a test, so tests don't depend on /run/kube-router being
+// writable, and restores it afterwards.
+func withTempCNILockDir(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "kube-router-cni-lock")
+	if err != nil {
+		t.Fatalf("failed to create temp lock dir: %v", err)
+	}
+
+	previous := cniLockDir
+	cniLockDir = dir
+	return func() {
+		cniLockDir = previous
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_WithCNIConfigLock(t *testing.T) {
+	defer withTempCNILockDir(t)()
+
+	content := `{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`
+	file, err := createFile(content, "/tmp/10-kuberouter-lock.conf")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	err = WithCNIConfigLock(file.Name(), func(cniConfig *CNIConfig) error {
+		ipamConfig, err := cniConfig.ipamConfig()
+		if err != nil {
+			return err
+		}
+		ipamConfig.SetSubnets([]string{"172.17.0.0/24"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cidr, err := GetPodCidrFromCniSpec(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error reading back CNI config: %v", err)
+	}
+	if cidr.String() != "172.17.0.0/24" {
+		t.Errorf("expected subnet 172.17.0.0/24, got %v", cidr.String())
+	}
+
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error stat-ing CNI config file: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected mutation through WithCNIConfigLock to preserve mode 0644, got %v", info.Mode().Perm())
+	}
+}
+
+func Test_InsertPodCidrsInCniSpec_ConcurrentWriters(t *testing.T) {
+	defer withTempCNILockDir(t)()
+
+	content := `{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`
+	file, err := createFile(content, "/tmp/10-kuberouter-concurrent.conf")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	const n = 20
+	candidates := make(map[string]bool, n)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		subnet := fmt.Sprintf("10.%d.0.0/24", i)
+		candidates[subnet] = true
+
+		_, cidr, parseErr := net.ParseCIDR(subnet)
+		if parseErr != nil {
+			t.Fatalf("failed to build test CIDR: %v", parseErr)
+		}
+
+		wg.Add(1)
+		go func(cidr net.IPNet) {
+			defer wg.Done()
+			if err := InsertPodCidrsInCniSpec(file.Name(), []net.IPNet{cidr}); err != nil {
+				errs <- err
+			}
+		}(*cidr)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected error from a concurrent writer: %v", err)
+	}
+
+	cniConfig, err := LoadCNIConfig(file.Name())
+	if err != nil {
+		t.Fatalf("final CNI config file did not parse cleanly: %v", err)
+	}
+
+	ipamConfig, err := cniConfig.ipamConfig()
+	if err != nil {
+		t.Fatalf("final CNI config file is missing its ipam block: %v", err)
+	}
+
+	if !candidates[ipamConfig.Subnet] {
+		t.Errorf("final subnet %q was not one of the concurrent writers' values", ipamConfig.Subnet)
+	}
+}
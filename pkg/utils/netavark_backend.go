@@ -0,0 +1,146 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// NetavarkSubnet is one entry of a NetavarkNetwork's "subnets" list.
+type NetavarkSubnet struct {
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// NetavarkNetwork mirrors the subset of containers/common's libnetwork
+// network definition that kube-router needs to read and write: the bridge
+// interface it rides on, the subnets/gateways assigned to it, and the ipam
+// driver and DNS setting used to bring pods up.
+type NetavarkNetwork struct {
+	NetworkInterface string            `json:"network_interface"`
+	Driver           string            `json:"driver"`
+	IPAMOptions      map[string]string `json:"ipam_options,omitempty"`
+	Subnets          []NetavarkSubnet  `json:"subnets"`
+	DNSEnabled       bool              `json:"dns_enabled"`
+}
+
+// loadNetavarkNetwork reads the netavark network definition at path.
+func loadNetavarkNetwork(path string) (*NetavarkNetwork, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netavark network config: %v", err)
+	}
+
+	network := &NetavarkNetwork{}
+	if err = json.Unmarshal(data, network); err != nil {
+		return nil, fmt.Errorf("failed to parse netavark network config: %v", err)
+	}
+
+	return network, nil
+}
+
+// save writes the netavark network definition back to path.
+func (n *NetavarkNetwork) save(path string) error {
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal netavark network config: %v", err)
+	}
+
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write netavark network config: %v", err)
+	}
+
+	return nil
+}
+
+// netavarkBackend is the NetworkBackend for hosts where the container
+// runtime stores its pod network definition as netavark JSON rather than a
+// CNI config file.
+type netavarkBackend struct {
+	bridgeName          string
+	networkConfFilePath string
+}
+
+func (b *netavarkBackend) GetPodCIDRs(node *apiv1.Node) ([]net.IPNet, error) {
+	network, err := loadNetavarkNetwork(b.networkConfFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cidrStrs := make([]string, 0, len(network.Subnets))
+	for _, subnet := range network.Subnets {
+		cidrStrs = append(cidrStrs, subnet.Subnet)
+	}
+
+	return orderedCIDRsByFamily(cidrStrs)
+}
+
+func (b *netavarkBackend) SetPodCIDRs(cidrs []net.IPNet) error {
+	if len(cidrs) == 0 {
+		return fmt.Errorf("no pod CIDRs to write to netavark network config")
+	}
+
+	network := &NetavarkNetwork{
+		NetworkInterface: b.bridgeName,
+		Driver:           "bridge",
+		DNSEnabled:       true,
+	}
+	if _, err := os.Stat(b.networkConfFilePath); err == nil {
+		existing, err := loadNetavarkNetwork(b.networkConfFilePath)
+		if err != nil {
+			return err
+		}
+		network = existing
+	}
+
+	subnets := make([]NetavarkSubnet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		subnets = append(subnets, NetavarkSubnet{
+			Subnet:  cidr.String(),
+			Gateway: firstUsableAddress(cidr).String(),
+		})
+	}
+	network.Subnets = subnets
+
+	return network.save(b.networkConfFilePath)
+}
+
+func (b *netavarkBackend) ReconcileBridge() error {
+	return ensureBridgeExists(b.bridgeName)
+}
+
+// firstUsableAddress returns the first address of cidr (i.e. cidr.IP + 1),
+// which is the gateway netavark assigns by convention when one is not
+// already configured.
+func firstUsableAddress(cidr net.IPNet) net.IP {
+	ip := make(net.IP, len(cidr.IP))
+	copy(ip, cidr.IP)
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+	return ip
+}
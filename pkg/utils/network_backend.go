@@ -0,0 +1,112 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// NetworkBackendCNI targets the CNI bridge+host-local plugin chain that
+	// kube-router has always driven directly.
+	NetworkBackendCNI = "cni"
+	// NetworkBackendNetavark targets containers/common's netavark network
+	// store, for hosts where the container runtime has moved off CNI.
+	NetworkBackendNetavark = "netavark"
+)
+
+// NetworkBackend abstracts how kube-router discovers and publishes a node's
+// pod CIDRs and keeps its local bridge in sync with them, so the agent can
+// target either a CNI-managed or a netavark-managed container runtime
+// without every caller knowing which one is in play.
+type NetworkBackend interface {
+	// GetPodCIDRs returns the pod CIDRs currently configured for node,
+	// IPv4 first then IPv6.
+	GetPodCIDRs(node *apiv1.Node) ([]net.IPNet, error)
+
+	// SetPodCIDRs publishes cidrs as the node's pod CIDRs, writing
+	// whatever on-disk config the underlying runtime reads at
+	// pod-sandbox-create time.
+	SetPodCIDRs(cidrs []net.IPNet) error
+
+	// ReconcileBridge ensures the local bridge interface used for pod
+	// networking exists and is up, creating it if necessary.
+	ReconcileBridge() error
+}
+
+// NewNetworkBackend constructs the NetworkBackend selected by the
+// --network-backend flag. cniConfFilePath and networkConfFilePath are only
+// read by the backend they apply to.
+func NewNetworkBackend(backend, bridgeName, cniConfFilePath, networkConfFilePath string) (NetworkBackend, error) {
+	switch backend {
+	case "", NetworkBackendCNI:
+		return &cniBackend{bridgeName: bridgeName, cniConfFilePath: cniConfFilePath}, nil
+	case NetworkBackendNetavark:
+		return &netavarkBackend{bridgeName: bridgeName, networkConfFilePath: networkConfFilePath}, nil
+	default:
+		return nil, fmt.Errorf("unknown --network-backend %q: must be %q or %q",
+			backend, NetworkBackendCNI, NetworkBackendNetavark)
+	}
+}
+
+// cniBackend is the default NetworkBackend, implemented on top of the
+// existing CNI config helpers in this package.
+type cniBackend struct {
+	bridgeName      string
+	cniConfFilePath string
+}
+
+func (b *cniBackend) GetPodCIDRs(node *apiv1.Node) ([]net.IPNet, error) {
+	return GetPodCidrsFromCniSpec(b.cniConfFilePath)
+}
+
+func (b *cniBackend) SetPodCIDRs(cidrs []net.IPNet) error {
+	return InsertPodCidrsInCniSpec(b.cniConfFilePath, cidrs)
+}
+
+func (b *cniBackend) ReconcileBridge() error {
+	return ensureBridgeExists(b.bridgeName)
+}
+
+// ensureBridgeExists creates the named Linux bridge if it does not already
+// exist and brings it up, regardless of which NetworkBackend is managing
+// the pod CIDRs assigned to it.
+func ensureBridgeExists(bridgeName string) error {
+	link, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return fmt.Errorf("failed to verify if bridge %s exists: %v", bridgeName, err)
+		}
+
+		bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: bridgeName}}
+		if err = netlink.LinkAdd(bridge); err != nil {
+			return fmt.Errorf("failed to create bridge %s: %v", bridgeName, err)
+		}
+		link = bridge
+	}
+
+	if err = netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up bridge %s: %v", bridgeName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_NewNetworkBackend(t *testing.T) {
+	testcases := []struct {
+		name    string
+		backend string
+		wantCNI bool
+		wantErr bool
+	}{
+		{"defaults to cni", "", true, false},
+		{"explicit cni", NetworkBackendCNI, true, false},
+		{"netavark", NetworkBackendNetavark, false, false},
+		{"unknown backend", "wireguard", false, true},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			backend, err := NewNetworkBackend(testcase.backend, "kube-bridge", "/tmp/does-not-matter.conf", "/tmp/does-not-matter.json")
+			if testcase.wantErr {
+				if err == nil {
+					t.Fatal("expected an error for an unknown network backend")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, isCNI := backend.(*cniBackend)
+			if isCNI != testcase.wantCNI {
+				t.Errorf("expected cniBackend=%v, got %T", testcase.wantCNI, backend)
+			}
+		})
+	}
+}
+
+func Test_CNIBackend_GetSetPodCIDRs(t *testing.T) {
+	content := `{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`
+	file, err := createFile(content, "/tmp/10-kuberouter-backend.conf")
+	if err != nil {
+		t.Fatalf("failed to create temporary CNI config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	backend, err := NewNetworkBackend(NetworkBackendCNI, "kube-bridge", file.Name(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []net.IPNet{
+		{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)},
+		{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+	}
+	if err = backend.SetPodCIDRs(want); err != nil {
+		t.Fatalf("unexpected error setting pod CIDRs: %v", err)
+	}
+
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	got, err := backend.GetPodCIDRs(node)
+	if err != nil {
+		t.Fatalf("unexpected error getting pod CIDRs: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Logf("actual pod CIDRs: %v", got)
+		t.Logf("expected pod CIDRs: %v", want)
+		t.Error("cniBackend did not round-trip the configured pod CIDRs")
+	}
+}
+
+func Test_NetavarkBackend_GetSetPodCIDRs(t *testing.T) {
+	path := "/tmp/10-kuberouter-netavark.json"
+	defer os.Remove(path)
+
+	backend, err := NewNetworkBackend(NetworkBackendNetavark, "kube-bridge", "", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []net.IPNet{
+		{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)},
+	}
+	if err = backend.SetPodCIDRs(want); err != nil {
+		t.Fatalf("unexpected error setting pod CIDRs: %v", err)
+	}
+
+	network, err := loadNetavarkNetwork(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading saved network config: %v", err)
+	}
+	if network.NetworkInterface != "kube-bridge" || network.Driver != "bridge" {
+		t.Errorf("expected defaults to be filled in on first write, got %+v", network)
+	}
+	if len(network.Subnets) != 1 || network.Subnets[0].Gateway != "172.17.0.1" {
+		t.Errorf("expected a derived gateway of 172.17.0.1, got %+v", network.Subnets)
+	}
+
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	got, err := backend.GetPodCIDRs(node)
+	if err != nil {
+		t.Fatalf("unexpected error getting pod CIDRs: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Logf("actual pod CIDRs: %v", got)
+		t.Logf("expected pod CIDRs: %v", want)
+		t.Error("netavarkBackend did not round-trip the configured pod CIDRs")
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GetNodeObject returns the node API object for the node kube-router is
+// currently running on. It tries, in order, the NODE_NAME environment
+// variable (set by the downward API), the --hostname-override flag, and
+// finally the machine's own hostname.
+func GetNodeObject(clientset kubernetes.Interface, hostnameOverride string) (*apiv1.Node, error) {
+	if nodeName := os.Getenv("NODE_NAME"); nodeName != "" {
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+		if err == nil {
+			return node, nil
+		}
+	}
+
+	if hostnameOverride != "" {
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), hostnameOverride, metav1.GetOptions{})
+		if err == nil {
+			return node, nil
+		}
+	}
+
+	hostName, _ := os.Hostname()
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), hostName, metav1.GetOptions{})
+	if err == nil {
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("failed to identify the node by NODE_NAME, hostname or --hostname-override")
+}
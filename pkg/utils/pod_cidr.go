@@ -0,0 +1,202 @@
+/*
+Copyright 2021, Pelion IoT and affiliates.
+Copyright 2017-2021, CloudNative Labs
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	podCIDRAnnotation  = "kube-router.io/pod-cidr"
+	podCIDRsAnnotation = "kube-router.io/pod-cidrs"
+)
+
+// GetPodCidrFromNodeSpec reads the pod CIDR assigned to this node, preferring
+// podCidr (typically sourced from the --pod-cidr command line argument) when
+// it is non-empty, then falling back to the node's own Spec.PodCIDR, and
+// finally to the kube-router.io/pod-cidr annotation.
+func GetPodCidrFromNodeSpec(clientset kubernetes.Interface, hostnameOverride string, podCidr string) (string, error) {
+	if podCidr != "" {
+		return podCidr, nil
+	}
+
+	node, err := GetNodeObject(clientset, hostnameOverride)
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod CIDR allocated for the node due to: %v", err)
+	}
+
+	if node.Spec.PodCIDR != "" {
+		return node.Spec.PodCIDR, nil
+	}
+
+	if cidr, ok := node.Annotations[podCIDRAnnotation]; ok {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return "", fmt.Errorf("error parsing pod CIDR in node annotation: %v", err)
+		}
+		return cidr, nil
+	}
+
+	return "", nil
+}
+
+// GetPodCidrsFromNodeSpec is the dual-stack counterpart of
+// GetPodCidrFromNodeSpec. It returns every pod CIDR assigned to the node,
+// IPv4 CIDRs first followed by IPv6 CIDRs, sourced in order of preference
+// from node.Spec.PodCIDRs, node.Spec.PodCIDR, and the comma-separated
+// kube-router.io/pod-cidrs annotation (falling back to the legacy singular
+// kube-router.io/pod-cidr annotation).
+func GetPodCidrsFromNodeSpec(clientset kubernetes.Interface, hostnameOverride string) ([]net.IPNet, error) {
+	node, err := GetNodeObject(clientset, hostnameOverride)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod CIDRs allocated for the node due to: %v", err)
+	}
+
+	return podCIDRsFromNode(node)
+}
+
+// podCIDRsFromNode extracts a node's pod CIDRs without needing a live API
+// client, so NetworkBackend implementations can reuse it directly on a node
+// object they already have in hand.
+func podCIDRsFromNode(node *apiv1.Node) ([]net.IPNet, error) {
+	var cidrStrs []string
+	switch {
+	case len(node.Spec.PodCIDRs) > 0:
+		cidrStrs = node.Spec.PodCIDRs
+	case node.Spec.PodCIDR != "":
+		cidrStrs = []string{node.Spec.PodCIDR}
+	default:
+		if ann, ok := node.Annotations[podCIDRsAnnotation]; ok {
+			for _, cidr := range strings.Split(ann, ",") {
+				cidrStrs = append(cidrStrs, strings.TrimSpace(cidr))
+			}
+		} else if ann, ok := node.Annotations[podCIDRAnnotation]; ok {
+			cidrStrs = []string{ann}
+		}
+	}
+
+	return orderedCIDRsByFamily(cidrStrs)
+}
+
+// orderedCIDRsByFamily parses the given CIDR strings and returns them with
+// IPv4 CIDRs sorted ahead of IPv6 CIDRs, preserving relative order within
+// each family.
+func orderedCIDRsByFamily(cidrStrs []string) ([]net.IPNet, error) {
+	var v4CIDRs, v6CIDRs []net.IPNet
+	for _, cidrStr := range cidrStrs {
+		_, ipNet, err := net.ParseCIDR(cidrStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing pod CIDR %q: %v", cidrStr, err)
+		}
+		if ipNet.IP.To4() != nil {
+			v4CIDRs = append(v4CIDRs, *ipNet)
+		} else {
+			v6CIDRs = append(v6CIDRs, *ipNet)
+		}
+	}
+
+	return append(v4CIDRs, v6CIDRs...), nil
+}
+
+// GetPodCidrFromCniSpec reads the pod CIDR subnet out of the host-local IPAM
+// block of the CNI config file at cniConfFilePath. It reads only the
+// single-family "subnet" field and returns a zero-value net.IPNet, nil when
+// that field is not set (e.g. before kube-router has assigned one, or when
+// the block uses the multi-family "ranges" form). It is a thin wrapper
+// around the typed CNIConfig model.
+func GetPodCidrFromCniSpec(cniConfFilePath string) (net.IPNet, error) {
+	cniConfig, err := LoadCNIConfig(cniConfFilePath)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+
+	ipamConfig, err := cniConfig.ipamConfig()
+	if err != nil {
+		return net.IPNet{}, err
+	}
+
+	if ipamConfig.Subnet == "" {
+		return net.IPNet{}, nil
+	}
+
+	_, cidr, err := net.ParseCIDR(ipamConfig.Subnet)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("failed to parse subnet in CNI conf file due to: %v", err)
+	}
+
+	return *cidr, nil
+}
+
+// GetPodCidrsFromCniSpec is the dual-stack counterpart of
+// GetPodCidrFromCniSpec. It returns the pod CIDRs configured in the
+// host-local IPAM block, whether it was written in the single-family
+// "subnet" form or the multi-family "ranges" form (the first range of each
+// range set), IPv4 first then IPv6.
+func GetPodCidrsFromCniSpec(cniConfFilePath string) ([]net.IPNet, error) {
+	cniConfig, err := LoadCNIConfig(cniConfFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ipamConfig, err := cniConfig.ipamConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return orderedCIDRsByFamily(ipamConfig.Subnets())
+}
+
+// InsertPodCidrInCniSpec inserts the given single-family cidr into the
+// host-local IPAM block of the CNI config file, handling both flat .conf
+// files and .conflist files with a "plugins" array.
+func InsertPodCidrInCniSpec(cniConfFilePath string, cidr string) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("failed to parse pod CIDR %q: %v", cidr, err)
+	}
+	return InsertPodCidrsInCniSpec(cniConfFilePath, []net.IPNet{*ipNet})
+}
+
+// InsertPodCidrsInCniSpec inserts one or more pod CIDRs into the host-local
+// IPAM block of the CNI config file at cniConfFilePath. A single CIDR is
+// written using the plain "subnet" field (the form every CNI host-local
+// version understands); two or more CIDRs upgrade the IPAM block to the
+// "ranges" form, one range set per CIDR, ordered to match cidrs.
+func InsertPodCidrsInCniSpec(cniConfFilePath string, cidrs []net.IPNet) error {
+	if len(cidrs) == 0 {
+		return fmt.Errorf("no pod CIDRs to insert into CNI config file")
+	}
+
+	subnets := make([]string, len(cidrs))
+	for i, cidr := range cidrs {
+		subnets[i] = cidr.String()
+	}
+
+	return WithCNIConfigLock(cniConfFilePath, func(cniConfig *CNIConfig) error {
+		ipamConfig, err := cniConfig.ipamConfig()
+		if err != nil {
+			return err
+		}
+		ipamConfig.SetSubnets(subnets)
+		return nil
+	})
+}
@@ -268,6 +268,191 @@ func Test_GetPodCIDRFromArgument(t *testing.T) {
 	})
 }
 
+func Test_GetPodCidrsFromCniSpec(t *testing.T) {
+	testcases := []struct {
+		name        string
+		cniConfFile string
+		podCidrs    []net.IPNet
+		filename    string
+	}{
+		{
+			"v4-only subnet",
+			`{"bridge":"kube-bridge","ipam":{"subnet":"172.17.0.0/24","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			[]net.IPNet{{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)}},
+			"/tmp/10-kuberouter-v4.conf",
+		},
+		{
+			"v6-only subnet",
+			`{"bridge":"kube-bridge","ipam":{"subnet":"fd00::/64","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			[]net.IPNet{{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)}},
+			"/tmp/10-kuberouter-v6.conf",
+		},
+		{
+			"v4+v6 ranges",
+			`{"bridge":"kube-bridge","ipam":{"ranges":[[{"subnet":"172.17.0.0/24"}],[{"subnet":"fd00::/64"}]],"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			[]net.IPNet{
+				{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)},
+				{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+			},
+			"/tmp/10-kuberouter-dual.conf",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			file, err := createFile(testcase.cniConfFile, testcase.filename)
+			if err != nil {
+				t.Fatalf("failed to create temporary CNI config file: %v", err)
+			}
+			defer os.Remove(file.Name())
+
+			cidrs, err := GetPodCidrsFromCniSpec(file.Name())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(cidrs, testcase.podCidrs) {
+				t.Logf("actual pod cidrs: %v", cidrs)
+				t.Logf("expected pod cidrs: %v", testcase.podCidrs)
+				t.Error("did not get expected pod cidrs")
+			}
+		})
+	}
+}
+
+func Test_InsertPodCidrsInCniSpec(t *testing.T) {
+	testcases := []struct {
+		name        string
+		podCidrs    []net.IPNet
+		existingCni string
+		newCni      string
+		filename    string
+	}{
+		{
+			"v4-only insertion into .conf",
+			[]net.IPNet{{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)}},
+			`{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			`{"bridge":"kube-bridge","ipam":{"subnet":"172.17.0.0/24","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			"/tmp/10-kuberouter-insert-v4.conf",
+		},
+		{
+			"v6-only insertion into .conf",
+			[]net.IPNet{{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)}},
+			`{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			`{"bridge":"kube-bridge","ipam":{"subnet":"fd00::/64","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			"/tmp/10-kuberouter-insert-v6.conf",
+		},
+		{
+			"v4+v6 insertion into .conflist",
+			[]net.IPNet{
+				{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)},
+				{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+			},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"},{"type":"portmap"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"ranges":[[{"subnet":"172.17.0.0/24"}],[{"subnet":"fd00::/64"}]],"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"},{"type":"portmap"}]}`,
+			"/tmp/10-kuberouter-insert-dual.conflist",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			cniConfigFile, err := createFile(testcase.existingCni, testcase.filename)
+			if err != nil {
+				t.Fatalf("failed to create temporary CNI config: %v", err)
+			}
+			defer os.Remove(cniConfigFile.Name())
+
+			if err = InsertPodCidrsInCniSpec(cniConfigFile.Name(), testcase.podCidrs); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			newContent, err := readFile(cniConfigFile.Name())
+			if err != nil {
+				t.Fatalf("failed to read CNI config file: %v", err)
+			}
+
+			if newContent != testcase.newCni {
+				t.Logf("actual CNI config: %v", newContent)
+				t.Logf("expected CNI config: %v", testcase.newCni)
+				t.Error("did not get expected CNI config content")
+			}
+
+			cidrs, err := GetPodCidrsFromCniSpec(cniConfigFile.Name())
+			if err != nil {
+				t.Fatalf("unexpected error reading back CNI config: %v", err)
+			}
+			if !reflect.DeepEqual(cidrs, testcase.podCidrs) {
+				t.Logf("actual round-tripped pod cidrs: %v", cidrs)
+				t.Logf("expected round-tripped pod cidrs: %v", testcase.podCidrs)
+				t.Error("did not get expected pod cidrs on round-trip read-back")
+			}
+		})
+	}
+}
+
+func Test_GetPodCidrsFromNodeSpec(t *testing.T) {
+	testcases := []struct {
+		name             string
+		hostnameOverride string
+		existingNode     *apiv1.Node
+		podCIDRs         []net.IPNet
+	}{
+		{
+			"node with node.Spec.PodCIDRs dual-stack",
+			"test-node",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+				},
+				Spec: apiv1.NodeSpec{
+					PodCIDRs: []string{"172.17.0.0/24", "fd00::/64"},
+				},
+			},
+			[]net.IPNet{
+				{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)},
+				{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+			},
+		},
+		{
+			"node with kube-router.io/pod-cidrs annotation",
+			"test-node",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+					Annotations: map[string]string{
+						podCIDRsAnnotation: "172.17.0.0/24,fd00::/64",
+					},
+				},
+			},
+			[]net.IPNet{
+				{IP: net.IP{172, 17, 0, 0}, Mask: net.CIDRMask(24, 32)},
+				{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			_, err := clientset.CoreV1().Nodes().Create(context.Background(), testcase.existingNode, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatalf("failed to create existing nodes for test: %v", err)
+			}
+
+			podCIDRs, err := GetPodCidrsFromNodeSpec(clientset, testcase.hostnameOverride)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(podCIDRs, testcase.podCIDRs) {
+				t.Logf("actual podCIDRs: %v", podCIDRs)
+				t.Logf("expected podCIDRs: %v", testcase.podCIDRs)
+				t.Error("did not get expected podCIDRs")
+			}
+		})
+	}
+}
+
 func createFile(content, filename string) (*os.File, error) {
 	file, err := os.Create(filename)
 	if err != nil {